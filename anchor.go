@@ -0,0 +1,412 @@
+package datemath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ParseAnchor parses a as an absolute date using a single-pass scanner that
+// classifies the bytes of a into digit runs, letter runs, and literal
+// separators, and from them derives a time.Parse reference layout -- rather
+// than trying a fixed list of candidate layouts against a in turn. This lets
+// it recognize the wide variety of formats found in real-world logs and
+// human-written dates (RFC 1123, slash-separated, compact "YYMMDD", locale
+// month/weekday names, and so on) with a single time.Parse call.
+//
+// Numeric day/month fields that could be read either way, such as the "03"
+// in "03/04/2014", are resolved using WithPreferMonthFirst (the default) or
+// reported as an error under WithParseStrict.
+func ParseAnchor(a string, opts ...Option) (time.Time, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return parseAnchorFlexible(a, o)
+}
+
+// anchorTokKind classifies a run produced by scanAnchorTokens.
+type anchorTokKind int
+
+const (
+	anchorDigits anchorTokKind = iota
+	anchorAlpha
+	anchorOther
+)
+
+// anchorTok is one run of an anchor string: a maximal run of digits, a
+// maximal run of letters, or a single separator byte.
+type anchorTok struct {
+	kind anchorTokKind
+	text string
+}
+
+// scanAnchorTokens makes a single pass over a, grouping consecutive digits
+// and consecutive letters into runs and leaving every other rune (spaces,
+// "/", "-", ":", ",", etc.) as its own token. Letter runs are grouped by
+// Unicode letter class, not just ASCII a-z/A-Z, so a locale's non-ASCII
+// month/weekday names (e.g. "März", or a script with no ASCII letters at
+// all) form one run instead of being shredded into single-rune "other"
+// tokens. The resulting token list is then resolved into a layout in a
+// second, much shorter pass -- this is what lets the resolution step look
+// at an ambiguous run's neighbors without ever re-scanning a itself.
+func scanAnchorTokens(a string) []anchorTok {
+	var toks []anchorTok
+	runes := []rune(a)
+	i := 0
+	for i < len(runes) {
+		switch r := runes[i]; {
+		case isAnchorDigit(r):
+			start := i
+			for i < len(runes) && isAnchorDigit(runes[i]) {
+				i++
+			}
+			toks = append(toks, anchorTok{anchorDigits, string(runes[start:i])})
+		case isAnchorAlpha(r):
+			start := i
+			for i < len(runes) && isAnchorAlpha(runes[i]) {
+				i++
+			}
+			toks = append(toks, anchorTok{anchorAlpha, string(runes[start:i])})
+		default:
+			toks = append(toks, anchorTok{anchorOther, string(r)})
+			i++
+		}
+	}
+	return toks
+}
+
+func isAnchorDigit(r rune) bool { return r >= '0' && r <= '9' }
+func isAnchorAlpha(r rune) bool { return unicode.IsLetter(r) }
+
+var anchorWeekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var anchorMonthNames = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+var anchorTZAbbrevs = []string{"UTC", "GMT", "UT", "EST", "EDT", "CST", "CDT", "MST", "MDT", "PST", "PDT"}
+
+func weekdayLayout(s string) (string, bool) {
+	for _, name := range anchorWeekdayNames {
+		if strings.EqualFold(s, name) {
+			return "Monday", true
+		}
+		if strings.EqualFold(s, name[:3]) {
+			return "Mon", true
+		}
+	}
+	return "", false
+}
+
+func monthLayout(s string) (string, bool) {
+	for _, name := range anchorMonthNames {
+		if strings.EqualFold(s, name) {
+			return "January", true
+		}
+		if strings.EqualFold(s, name[:3]) {
+			return "Jan", true
+		}
+	}
+	return "", false
+}
+
+func isMeridiemWord(s string) bool {
+	return strings.EqualFold(s, "am") || strings.EqualFold(s, "pm")
+}
+
+func isTZAbbrev(s string) bool {
+	for _, z := range anchorTZAbbrevs {
+		if strings.EqualFold(s, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekIsMonthNameAhead reports whether the next alphabetic token at or after
+// i, skipping over separators, is a month name (English, or one of l's). It's
+// used to recognize a day field that appears before the month, as in
+// "14 May 2019".
+func peekIsMonthNameAhead(toks []anchorTok, i int, l Locale) bool {
+	for ; i < len(toks); i++ {
+		switch toks[i].kind {
+		case anchorOther:
+			continue
+		case anchorAlpha:
+			if _, _, ok := localeMonthLayout(l, toks[i].text); ok {
+				return true
+			}
+			_, ok := monthLayout(toks[i].text)
+			return ok
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// anchorFieldState tracks which date and time components have been assigned
+// a layout token so far, so that later, ambiguous runs can be resolved
+// relative to what's already known (e.g. once a month is assigned, the next
+// bare number must be the day).
+type anchorFieldState struct {
+	haveYear, haveMonth, haveDay bool
+	haveHour, haveMin, haveSec   bool
+	haveZone, zoneStarted        bool
+}
+
+// parseAnchorFlexible scans a once into tokens, then walks that token list
+// building a time.Parse layout, and finally makes the single time.Parse
+// call.
+func parseAnchorFlexible(a string, o Options) (time.Time, error) {
+	toks := scanAnchorTokens(a)
+
+	hasMeridiem := false
+	for _, t := range toks {
+		if t.kind == anchorAlpha && isMeridiemWord(t.text) {
+			hasMeridiem = true
+			break
+		}
+	}
+
+	// layout accumulates the time.Parse reference layout; rewritten
+	// accumulates the string that layout is actually matched against. They're
+	// usually identical to the corresponding slice of a, except where a
+	// locale token (see locale.go) is substituted with its English
+	// equivalent, since the time package's month/weekday verbs only ever
+	// recognize English names.
+	var layout strings.Builder
+	var rewritten strings.Builder
+	var st anchorFieldState
+
+	for i, t := range toks {
+		switch t.kind {
+		case anchorOther:
+			if t.text == "+" && st.haveHour && !st.haveZone && i+1 < len(toks) && toks[i+1].kind == anchorDigits {
+				// A numeric zone offset's sign: the layout's "-0700"/"-07:00"
+				// verbs match either "+" or "-", whereas a literal "+" would
+				// leave the offset digits without a zone verb to match.
+				layout.WriteString("-")
+			} else {
+				layout.WriteString(t.text)
+			}
+			rewritten.WriteString(t.text)
+		case anchorAlpha:
+			layoutTok, rewrittenTok, err := resolveAlphaToken(t.text, &st, o)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("could not parse %q: %w", a, err)
+			}
+			layout.WriteString(layoutTok)
+			rewritten.WriteString(rewrittenTok)
+		case anchorDigits:
+			tok, err := resolveDigitsToken(toks, i, o, &st, hasMeridiem)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("could not parse %q: %w", a, err)
+			}
+			layout.WriteString(tok)
+			rewritten.WriteString(t.text)
+		}
+	}
+
+	loc := o.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(layout.String(), rewritten.String(), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse %q as a date using derived layout %q: %w", a, layout.String(), err)
+	}
+	return t, nil
+}
+
+// resolveAlphaToken maps one alphabetic run to a layout token and the text
+// to match that token against: a bare "Z" (Zulu/UTC) or "T" (the RFC3339
+// date/time separator) is kept literal, then meridiem markers and time zone
+// abbreviations are tried, then -- when
+// o.locale is set -- its weekday/month names take priority over the English
+// ones, since a locale's abbreviations can otherwise collide with an
+// unrelated English word (e.g. Spanish "mar" for Tuesday vs. English "Mar"
+// for March). English weekday/month names are always tried as a fallback so
+// an anchor can still mix an English month with, say, a locale weekday.
+// Resolving a month name (English or localized) sets st.haveMonth so that a
+// numeric day elsewhere in the anchor -- before or after the month name --
+// is no longer treated as ambiguous.
+func resolveAlphaToken(text string, st *anchorFieldState, o Options) (layoutTok, matchTok string, err error) {
+	if text == "Z" {
+		return "Z", text, nil
+	}
+	if text == "T" {
+		// The RFC3339 date/time separator: kept literal, like the "Z"
+		// (Zulu/UTC) designator above, rather than treated as a
+		// weekday/month/meridiem/zone name.
+		return "T", text, nil
+	}
+	if isMeridiemWord(text) {
+		if text == strings.ToUpper(text) {
+			return "PM", text, nil
+		}
+		return "pm", text, nil
+	}
+	if isTZAbbrev(text) {
+		return "MST", text, nil
+	}
+	if lt, en, ok := localeWeekdayLayout(o.locale, text); ok {
+		return lt, en, nil
+	}
+	if lt, en, ok := localeMonthLayout(o.locale, text); ok {
+		st.haveMonth = true
+		return lt, en, nil
+	}
+	if wd, ok := weekdayLayout(text); ok {
+		return wd, text, nil
+	}
+	if mo, ok := monthLayout(text); ok {
+		st.haveMonth = true
+		return mo, text, nil
+	}
+	return "", "", fmt.Errorf("unrecognized weekday, month, meridiem, or time zone name %q", text)
+}
+
+// resolveDigitsToken decides what a single run of digits represents --
+// a year, a compact "YYMMDD" date, an hour/minute/second, a fractional
+// second, a numeric zone offset, or an ambiguous month/day field -- using
+// its length and its immediate neighbors in the token stream, and updates st
+// to reflect the choice.
+func resolveDigitsToken(toks []anchorTok, i int, o Options, st *anchorFieldState, hasMeridiem bool) (string, error) {
+	text := toks[i].text
+	n := len(text)
+
+	prevOther := ""
+	if i > 0 && toks[i-1].kind == anchorOther {
+		prevOther = toks[i-1].text
+	}
+	nextOther := ""
+	if i+1 < len(toks) && toks[i+1].kind == anchorOther {
+		nextOther = toks[i+1].text
+	}
+
+	switch {
+	case prevOther == "." && st.haveSec:
+		// fractional seconds following an already-emitted HH:MM:SS
+		return strings.Repeat("0", n), nil
+
+	case st.zoneStarted && prevOther == ":":
+		return "00", nil
+
+	case (prevOther == "+" || prevOther == "-") && st.haveHour && !st.haveZone:
+		st.haveZone = true
+		st.zoneStarted = true
+		if n == 2 {
+			return "07", nil
+		}
+		return "0700", nil
+
+	case n == 4 && !st.haveYear && !st.haveHour:
+		st.haveYear = true
+		return "2006", nil
+
+	case n == 6 && !st.haveYear && !st.haveMonth && !st.haveDay && !st.haveHour:
+		st.haveYear, st.haveMonth, st.haveDay = true, true, true
+		return "060102", nil
+
+	case prevOther == ":" || nextOther == ":":
+		switch {
+		case !st.haveHour:
+			st.haveHour = true
+			if hasMeridiem {
+				return "3", nil
+			}
+			return "15", nil
+		case !st.haveMin:
+			st.haveMin = true
+			return "4", nil
+		default:
+			st.haveSec = true
+			return "5", nil
+		}
+
+	case !st.haveDay && peekIsMonthNameAhead(toks, i+1, o.locale):
+		st.haveDay = true
+		return "2", nil
+
+	case st.haveMonth && !st.haveDay:
+		st.haveDay = true
+		return "2", nil
+
+	case st.haveDay && !st.haveMonth:
+		st.haveMonth = true
+		return "1", nil
+
+	case st.haveMonth && st.haveDay && !st.haveYear:
+		st.haveYear = true
+		return "06", nil
+
+	default:
+		if val, err := strconv.Atoi(text); err == nil && val > 12 {
+			// Only a day is valid at this value, regardless of preference.
+			st.haveDay = true
+			return "2", nil
+		}
+		if o.parseStrict {
+			return "", fmt.Errorf("ambiguous month/day field %q; use WithPreferMonthFirst or disambiguate the date", text)
+		}
+		if o.preferMonthFirst {
+			st.haveMonth = true
+			return "1", nil
+		}
+		st.haveDay = true
+		return "2", nil
+	}
+}
+
+// splitAnchorMath separates a datemath expression into its anchor and
+// "||"-prefixed math suffix. "now" carries an implicit math suffix
+// whenever it's immediately followed by "+", "-", or "/" with no "||" in
+// between (e.g. "now-1h").
+func splitAnchorMath(s string) (anchorStr, mathStr string, mathOffset int, hasMath bool) {
+	if idx := strings.Index(s, "||"); idx >= 0 {
+		return s[:idx], s[idx+2:], idx + 2, true
+	}
+	for _, op := range []byte{'+', '-', '/'} {
+		if strings.HasPrefix(s, "now") && len(s) > 3 && s[3] == op {
+			return "now", s[3:], 3, true
+		}
+	}
+	return s, "", len(s), false
+}
+
+// parseExpressionFlexible is the fallback used by parseExpression when the
+// strict grammar can't make sense of s's anchor. It defers everything up to
+// the "||" (or the implicit one after a bare "now") to ParseAnchor, then
+// re-lexes whatever follows with parseMathOps so that "+N<unit>",
+// "-N<unit>", and "/<unit>" keep working unchanged on top of a
+// flexibly-parsed anchor.
+func parseExpressionFlexible(s string, opts []Option) (*Expr, error) {
+	anchorStr, mathStr, mathOffset, hasMath := splitAnchorMath(s)
+
+	var expr Expr
+	if anchorStr == "now" {
+		expr.isNow = true
+	} else {
+		o := defaultOptions()
+		for _, opt := range opts {
+			opt(&o)
+		}
+		t, err := parseAnchorFlexible(anchorStr, o)
+		if err != nil {
+			return nil, err
+		}
+		expr.anchor = dateAnchor{hasAbs: true, abs: t}
+	}
+
+	if !hasMath {
+		return &expr, nil
+	}
+
+	lx := newLexerAt(mathStr, mathOffset)
+	ops, err := parseMathOps(lx, lx.next())
+	if err != nil {
+		return nil, err
+	}
+	expr.ops = ops
+	return &expr, nil
+}
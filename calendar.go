@@ -0,0 +1,236 @@
+package datemath
+
+import "time"
+
+// BusinessCalendar supplies the definition of a business day and its
+// open/close hours, consulted by the "b" unit's business-day check and by
+// the "bh"/"bd" business-hour units and rounding operations.
+type BusinessCalendar interface {
+	// IsBusinessDay reports whether t's calendar day, in Location, is a
+	// business day, e.g. false for a weekend or a holiday.
+	IsBusinessDay(t time.Time) bool
+	// DayWindow returns the open and close time of business for t's
+	// calendar day, each expressed as a duration since that day's local
+	// midnight, e.g. (9*time.Hour, 17*time.Hour) for a 9-to-5 day. Only
+	// called for days IsBusinessDay reports true for.
+	DayWindow(t time.Time) (open, close time.Duration)
+	// Location is the time zone that business day boundaries, and
+	// DayWindow's open/close durations, are anchored to.
+	Location() *time.Location
+}
+
+// WithBusinessCalendar sets the calendar consulted by the "b" unit (which
+// otherwise only checks Monday-Friday) and by the "bh"/"bd" business-hour
+// units and rounding operations. Defaults to nil, which treats every
+// weekday as an ordinary business day and makes "bh"/"bd" fall back to
+// plain "h"/"d" behavior.
+func WithBusinessCalendar(c BusinessCalendar) Option {
+	return func(o *Options) { o.businessCalendar = c }
+}
+
+// businessDayFuncCalendar adapts a WithBusinessDayFunc predicate to a
+// BusinessCalendar so that "b" has a single code path regardless of which
+// option was used to configure it. Its DayWindow reports a full
+// midnight-to-midnight window, since a bare predicate has no way to express
+// business hours; use WithBusinessCalendar directly for "bh"/"bd".
+type businessDayFuncCalendar struct {
+	f func(time.Time) bool
+}
+
+func (c businessDayFuncCalendar) IsBusinessDay(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	if c.f != nil {
+		return c.f(t)
+	}
+	return true
+}
+
+func (businessDayFuncCalendar) DayWindow(time.Time) (open, close time.Duration) {
+	return 0, 24 * time.Hour
+}
+
+func (businessDayFuncCalendar) Location() *time.Location { return time.UTC }
+
+// WithBusinessDayFunc sets a predicate used, in addition to the Monday-Friday
+// check, to decide whether a day counts as a business day for the "b" unit.
+// A nil func (the default) treats every weekday as a business day.
+//
+// Deprecated: use WithBusinessCalendar, which also supports the "bh"/"bd"
+// units and rounding operations.
+func WithBusinessDayFunc(f func(time.Time) bool) Option {
+	return func(o *Options) { o.businessCalendar = businessDayFuncCalendar{f: f} }
+}
+
+// isBusinessDay reports whether t is a business day, deferring to cal when
+// set and otherwise treating every weekday as a business day.
+func isBusinessDay(t time.Time, cal BusinessCalendar) bool {
+	if cal != nil {
+		return cal.IsBusinessDay(t)
+	}
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+// dayWindow returns the open and close instants, in cal's Location, of t's
+// calendar day.
+func dayWindow(t time.Time, cal BusinessCalendar) (open, close time.Time) {
+	loc := cal.Location()
+	local := t.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	o, c := cal.DayWindow(local)
+	return midnight.Add(o), midnight.Add(c)
+}
+
+// snapToOpen returns t itself if it already falls within a business day's
+// open window, that day's open instant if t is still before it, or else the
+// open instant of the next business day at or after t.
+func snapToOpen(t time.Time, cal BusinessCalendar) time.Time {
+	local := t.In(cal.Location())
+	if isBusinessDay(local, cal) {
+		open, close := dayWindow(local, cal)
+		if local.Before(open) {
+			return open
+		}
+		if !local.After(close) {
+			return local
+		}
+	}
+	for {
+		local = startOfDay(local, cal.Location()).AddDate(0, 0, 1)
+		if isBusinessDay(local, cal) {
+			open, _ := dayWindow(local, cal)
+			return open
+		}
+	}
+}
+
+// snapToClose is snapToOpen's mirror for walking backward: it returns t
+// itself if it already falls within a business day's open window, that
+// day's close instant if t is still after it, or else the close instant of
+// the previous business day at or before t.
+func snapToClose(t time.Time, cal BusinessCalendar) time.Time {
+	local := t.In(cal.Location())
+	if isBusinessDay(local, cal) {
+		open, close := dayWindow(local, cal)
+		if local.After(close) {
+			return close
+		}
+		if !local.Before(open) {
+			return local
+		}
+	}
+	for {
+		local = startOfDay(local, cal.Location()).Add(-time.Nanosecond)
+		if isBusinessDay(local, cal) {
+			_, close := dayWindow(local, cal)
+			return close
+		}
+	}
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
+
+// addBusinessHours steps t forward (amount > 0) or backward (amount < 0)
+// one minute at a time, snapping into the next open window whenever it
+// lands outside cal's business hours, until abs(amount) hours' worth of
+// in-window minutes have been crossed. A nil cal makes "bh" behave like the
+// plain "h" unit.
+func addBusinessHours(t time.Time, amount int, cal BusinessCalendar) time.Time {
+	if cal == nil {
+		return t.Add(time.Duration(amount) * time.Hour)
+	}
+
+	sign := 1
+	remaining := amount
+	if amount < 0 {
+		sign = -1
+		remaining = -amount
+	}
+	snap := snapToOpen
+	if sign < 0 {
+		snap = snapToClose
+	}
+
+	cur := snap(t, cal)
+	for remainingMinutes := remaining * 60; remainingMinutes > 0; remainingMinutes-- {
+		next := cur.Add(time.Duration(sign) * time.Minute)
+		cur = snap(next, cal)
+		if !cur.Equal(next) {
+			// next landed outside business hours; the jump to the next
+			// window's boundary is free and doesn't consume a business
+			// minute of its own.
+			remainingMinutes++
+		}
+	}
+	return cur
+}
+
+// addBusinessDays steps t forward (amount > 0) or backward (amount < 0) one
+// calendar day at a time, counting only days that pass isBusinessDay, until
+// abs(amount) such days have been crossed.
+func addBusinessDays(t time.Time, amount int, cal BusinessCalendar) time.Time {
+	sign := 1
+	remaining := amount
+	if amount < 0 {
+		sign = -1
+		remaining = -amount
+	}
+
+	cur := t
+	for remaining > 0 {
+		cur = cur.AddDate(0, 0, sign)
+		if isBusinessDay(cur, cal) {
+			remaining--
+		}
+	}
+	return cur
+}
+
+// businessDayBounds returns the open and close instants of t's calendar
+// day, for "/bd" rounding. If t doesn't fall on a business day (a weekend
+// or holiday), it's snapped first via snapToOpen/snapToClose -- forward for
+// roundUp, backward otherwise -- the same way addBusinessHours treats a
+// non-business gap as a free jump, so DayWindow is only ever consulted for
+// a day IsBusinessDay reports true for.
+func businessDayBounds(t time.Time, cal BusinessCalendar, roundUp bool) (start, next time.Time) {
+	anchor := t
+	if !isBusinessDay(t.In(cal.Location()), cal) {
+		if roundUp {
+			anchor = snapToOpen(t, cal)
+		} else {
+			anchor = snapToClose(t, cal)
+		}
+	}
+	return dayWindow(anchor, cal)
+}
+
+// businessHourBounds returns the top and bottom of the hour containing t,
+// clamped to cal's open/close window for t's calendar day, for "/bh"
+// rounding. As with businessDayBounds, a t outside any business day is
+// snapped to the nearest business instant first.
+func businessHourBounds(t time.Time, cal BusinessCalendar, roundUp bool) (start, next time.Time) {
+	loc := cal.Location()
+	local := t.In(loc)
+	if !isBusinessDay(local, cal) {
+		if roundUp {
+			local = snapToOpen(t, cal).In(loc)
+		} else {
+			local = snapToClose(t, cal).In(loc)
+		}
+	}
+	start = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, loc)
+	next = start.Add(time.Hour)
+
+	open, close := dayWindow(local, cal)
+	if start.Before(open) {
+		start = open
+	}
+	if next.After(close) {
+		next = close
+	}
+	return start, next
+}
@@ -0,0 +1,334 @@
+// Package datemath parses and evaluates Elasticsearch-style date math
+// expressions such as "now-1h", "now/d", and "2014-11-18||+1M/d".
+//
+// An expression is an anchor (either the literal "now" or a date) optionally
+// followed by "||" and a sequence of "+N<unit>", "-N<unit>", or "/<unit>"
+// operations applied left to right.
+package datemath
+
+import (
+	"encoding"
+	"encoding/json"
+	"time"
+)
+
+// Options holds the knobs that affect how an Expression is evaluated. It is
+// built up from a set of Option functions rather than constructed directly.
+type Options struct {
+	now               time.Time
+	nowSet            bool
+	clock             func() time.Time
+	location          *time.Location
+	businessCalendar  BusinessCalendar
+	roundUp           bool
+	startOfFiscalYear time.Time
+	startOfWeek       *time.Weekday
+	preferMonthFirst  bool
+	parseStrict       bool
+	locale            Locale
+}
+
+// Option configures an Options. It is an alias (not a distinct named type)
+// so that callers can build up a []func(*Options) without needing to name
+// this package.
+type Option = func(*Options)
+
+func defaultOptions() Options {
+	return Options{
+		clock:            time.Now,
+		location:         time.UTC,
+		preferMonthFirst: true,
+	}
+}
+
+// WithNow sets the time that "now" resolves to, taking precedence over
+// WithClock. Defaults to the result of the clock (time.Now unless WithClock
+// overrides it) at evaluation time.
+func WithNow(t time.Time) Option {
+	return func(o *Options) { o.now = t; o.nowSet = true }
+}
+
+// WithClock sets the function consulted to resolve "now" when WithNow
+// hasn't been given, in place of the default time.Now. Useful for
+// injecting a fake clock in tests without having to compute the expected
+// "now" up front for every ParseAndEvaluate call.
+func WithClock(f func() time.Time) Option {
+	return func(o *Options) { o.clock = f }
+}
+
+// WithLocation sets the location used to interpret anchor dates that don't
+// carry an explicit zone. Defaults to time.UTC.
+func WithLocation(loc *time.Location) Option {
+	return func(o *Options) {
+		if loc != nil {
+			o.location = loc
+		}
+	}
+}
+
+// WithRoundUp changes "/<unit>" rounding to round up to the end of the unit
+// (e.g. 23:59:59.999 for "/d") instead of down to its start.
+func WithRoundUp(b bool) Option {
+	return func(o *Options) { o.roundUp = b }
+}
+
+// WithStartOfFiscalYear sets the month, day, and time of day that a fiscal
+// year begins on, used by the "fy" and "fQ" units. The year component is
+// ignored. The zero value (the default) is equivalent to a fiscal year
+// starting on the ordinary calendar year.
+func WithStartOfFiscalYear(t time.Time) Option {
+	return func(o *Options) { o.startOfFiscalYear = t }
+}
+
+// WithStartOfWeek sets the weekday that "/w" rounds down to. Defaults to
+// time.Monday.
+func WithStartOfWeek(d time.Weekday) Option {
+	return func(o *Options) { o.startOfWeek = &d }
+}
+
+// WithPreferMonthFirst controls how ParseAnchor (and the flexible anchor
+// fallback used by Parse) resolves a numeric date field that could be
+// either a month or a day of month, such as the "03" in "03/04/2014".
+// Defaults to true (month-first, matching US convention) to match the most
+// common legacy log formats; has no effect once a field's value rules out
+// one of the two interpretations (e.g. "13" can only be a day).
+func WithPreferMonthFirst(preferMonthFirst bool) Option {
+	return func(o *Options) { o.preferMonthFirst = preferMonthFirst }
+}
+
+// WithParseStrict makes ParseAnchor return an error instead of guessing when
+// it encounters a numeric date field whose role (month vs. day) is
+// genuinely ambiguous, rather than falling back to WithPreferMonthFirst.
+func WithParseStrict() Option {
+	return func(o *Options) { o.parseStrict = true }
+}
+
+// Expression is a parsed datemath expression that can be evaluated to a
+// time.Time, possibly more than once and with different Options.
+//
+// Expression implements encoding.TextMarshaler/TextUnmarshaler and
+// json.Marshaler/Unmarshaler, marshaling to and from its canonical string
+// form (see String), so it round-trips through configs and APIs as plain
+// text. Because Expression is an interface, a struct field declared with
+// this interface type must already hold a value -- e.g. one obtained from
+// Parse -- before unmarshaling into it: encoding/json follows a non-nil
+// pointer already stored in an interface field and calls its UnmarshalJSON
+// in place, but it can't invent a concrete type for a nil interface. A
+// struct field that needs to decode fresh, without a priming call, should
+// be declared as the concrete Expr type instead, whose zero value already
+// implements this interface.
+type Expression interface {
+	// Time evaluates the expression, applying the given Options.
+	Time(opts ...Option) time.Time
+	// String re-serializes the parsed expression to its canonical form,
+	// which may differ cosmetically from the string it was parsed from
+	// (e.g. a bare "2014" becomes "2014-01-01T00:00:00.000") but always
+	// evaluates the same way.
+	String() string
+
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+	json.Marshaler
+	json.Unmarshaler
+
+	// Add, Sub, and Round return a copy of the expression with the
+	// corresponding "+N<unit>", "-N<unit>", or "/<unit>" operation
+	// appended to its AST, for composing expressions programmatically
+	// instead of concatenating onto their string form.
+	Add(amount int, unit string) Expression
+	Sub(amount int, unit string) Expression
+	Round(unit string) Expression
+}
+
+// Parse parses a datemath expression without evaluating it. The result can
+// be evaluated later, potentially several times, via Expression.Time.
+//
+// opts is only consulted for anchors that need the flexible parser from
+// anchor.go (see WithPreferMonthFirst and WithParseStrict); it has no effect
+// on anchors the strict grammar already understands.
+func Parse(s string, opts ...Option) (Expression, error) {
+	return parseExpression(s, opts...)
+}
+
+// ParseAndEvaluate parses and immediately evaluates a datemath expression.
+func ParseAndEvaluate(s string, opts ...Option) (time.Time, error) {
+	expr, err := Parse(s, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expr.Time(opts...), nil
+}
+
+// Time evaluates the expression against the given options, applying its
+// math operations in order to the anchor.
+func (e *Expr) Time(opts ...Option) time.Time {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.nowSet {
+		o.now = o.clock()
+	}
+
+	t := e.anchorTime(o)
+	for _, op := range e.ops {
+		t = applyOp(t, op, o)
+	}
+	return t
+}
+
+func (e *Expr) anchorTime(o Options) time.Time {
+	if e.isNow {
+		return o.now
+	}
+	if e.anchor.hasAbs {
+		return e.anchor.abs
+	}
+
+	loc := e.anchor.loc
+	if loc == nil {
+		loc = o.location
+	}
+	a := e.anchor
+	return time.Date(a.year, time.Month(a.month), a.day, a.hour, a.min, a.sec, a.nsec, loc)
+}
+
+func applyOp(t time.Time, op mathOp, o Options) time.Time {
+	switch op.kind {
+	case opAdd:
+		return addUnit(t, op.amount, op.unit, o)
+	case opSub:
+		return addUnit(t, -op.amount, op.unit, o)
+	case opRound:
+		return roundUnit(t, op.unit, o)
+	default:
+		return t
+	}
+}
+
+func addUnit(t time.Time, amount int, unit string, o Options) time.Time {
+	switch unit {
+	case "y", "fy":
+		return t.AddDate(amount, 0, 0)
+	case "M":
+		return t.AddDate(0, amount, 0)
+	case "Q", "fQ":
+		return t.AddDate(0, amount*3, 0)
+	case "w":
+		return t.AddDate(0, 0, amount*7)
+	case "d":
+		return t.AddDate(0, 0, amount)
+	case "h", "H":
+		return t.Add(time.Duration(amount) * time.Hour)
+	case "m":
+		return t.Add(time.Duration(amount) * time.Minute)
+	case "s":
+		return t.Add(time.Duration(amount) * time.Second)
+	case "b":
+		return addBusinessDays(t, amount, o.businessCalendar)
+	case "bh":
+		return addBusinessHours(t, amount, o.businessCalendar)
+	default:
+		return t
+	}
+}
+
+func roundUnit(t time.Time, unit string, o Options) time.Time {
+	start, next := periodBounds(t, unit, o)
+	if o.roundUp {
+		return next.Add(-time.Millisecond)
+	}
+	return start
+}
+
+// periodBounds returns the start of the period containing t for the given
+// unit, along with the start of the following period.
+func periodBounds(t time.Time, unit string, o Options) (start, next time.Time) {
+	loc := t.Location()
+
+	switch unit {
+	case "y":
+		start = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
+		next = start.AddDate(1, 0, 0)
+	case "M":
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 1, 0)
+	case "Q":
+		start = floorPeriod(t, time.Date(0, 1, 1, 0, 0, 0, 0, loc), 3)
+		next = start.AddDate(0, 3, 0)
+	case "fQ":
+		start = floorPeriod(t, fiscalYearAnchor(o, loc), 3)
+		next = start.AddDate(0, 3, 0)
+	case "fy":
+		start = floorPeriod(t, fiscalYearAnchor(o, loc), 12)
+		next = start.AddDate(1, 0, 0)
+	case "w":
+		sow := time.Monday
+		if fd, ok := localeFirstDay(o.locale); ok {
+			sow = fd
+		}
+		if o.startOfWeek != nil {
+			sow = *o.startOfWeek
+		}
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		diff := (int(midnight.Weekday()) - int(sow) + 7) % 7
+		start = midnight.AddDate(0, 0, -diff)
+		next = start.AddDate(0, 0, 7)
+	case "h", "H":
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		next = start.Add(time.Hour)
+	case "m":
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		next = start.Add(time.Minute)
+	case "s":
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+		next = start.Add(time.Second)
+	case "bd":
+		if o.businessCalendar == nil {
+			start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			next = start.AddDate(0, 0, 1)
+			break
+		}
+		start, next = businessDayBounds(t, o.businessCalendar, o.roundUp)
+	case "bh":
+		if o.businessCalendar == nil {
+			start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+			next = start.Add(time.Hour)
+			break
+		}
+		start, next = businessHourBounds(t, o.businessCalendar, o.roundUp)
+	default: // "d" and anything else falls back to calendar day
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 0, 1)
+	}
+	return start, next
+}
+
+func fiscalYearAnchor(o Options, loc *time.Location) time.Time {
+	if o.startOfFiscalYear.IsZero() {
+		return time.Date(0, 1, 1, 0, 0, 0, 0, loc)
+	}
+	fy := o.startOfFiscalYear
+	return time.Date(0, fy.Month(), fy.Day(), fy.Hour(), fy.Minute(), fy.Second(), fy.Nanosecond(), loc)
+}
+
+// floorPeriod finds the most recent instant of the form
+// anchor + k*monthsInPeriod months that is not after t.
+func floorPeriod(t, anchor time.Time, monthsInPeriod int) time.Time {
+	loc := t.Location()
+	// Each candidate is built from the anchor's own month and day rather than
+	// by stepping a previous candidate, so that an anchor such as Feb 29 is
+	// only normalized (to Mar 1) in the years that lack it.
+	at := func(k int) time.Time {
+		return time.Date(t.Year(), anchor.Month()+time.Month(k*monthsInPeriod), anchor.Day(), anchor.Hour(), anchor.Minute(), anchor.Second(), anchor.Nanosecond(), loc)
+	}
+
+	k := 0
+	for at(k).After(t) {
+		k--
+	}
+	for !at(k + 1).After(t) {
+		k++
+	}
+	return at(k)
+}
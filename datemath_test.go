@@ -1,6 +1,7 @@
 package datemath_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 	"time"
@@ -565,3 +566,268 @@ func ExampleParse() {
 	//2014-06-30 20:21:35.123 +0000 UTC
 	//2014-06-30 00:00:00 +0000 UTC
 }
+
+// TestParseAnchor exercises ParseAnchor's flexible, format-agnostic
+// scanning directly, including the RFC3339 "T"/"Z" separators that a
+// bare ISO-8601 anchor is made of.
+func TestParseAnchor(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "bare ISO8601 with T and Z",
+			in:   "2014-05-30T20:21:35Z",
+			want: "2014-05-30T20:21:35Z",
+		},
+		{
+			name: "slash-separated, month first",
+			in:   "05/30/2014",
+			want: "2014-05-30T00:00:00Z",
+		},
+		{
+			name: "compact YYMMDD",
+			in:   "140530",
+			want: "2014-05-30T00:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := datemath.ParseAnchor(tt.in)
+			if err != nil {
+				t.Fatalf("ParseAnchor(%q) returned error %q", tt.in, err)
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("ParseAnchor(%q) = %s, want %s", tt.in, got, want)
+			}
+		})
+	}
+}
+
+// fakeLocale is a minimal Locale implementation for testing WithLocale,
+// standing in for a real locales package that this module doesn't depend on.
+type fakeLocale struct{}
+
+func (fakeLocale) MonthWide(m time.Month) string            { return "Maand" + m.String() }
+func (fakeLocale) MonthAbbreviated(m time.Month) string     { return m.String()[:3] }
+func (fakeLocale) WeekdayWide(d time.Weekday) string        { return "Dag" + d.String() }
+func (fakeLocale) WeekdayAbbreviated(d time.Weekday) string { return d.String()[:3] }
+func (fakeLocale) FirstDay() time.Weekday                   { return time.Sunday }
+
+func TestWithLocale(t *testing.T) {
+	got, err := datemath.ParseAnchor("30 MaandMay 2014", datemath.WithLocale(fakeLocale{}))
+	if err != nil {
+		t.Fatalf("ParseAnchor returned error %q", err)
+	}
+	want, _ := time.Parse(time.RFC3339, "2014-05-30T00:00:00Z")
+	if !got.Equal(want) {
+		t.Errorf("ParseAnchor with a wide locale month name = %s, want %s", got, want)
+	}
+
+	// The locale's FirstDay should become the default "/w" start of week.
+	out, err := datemath.ParseAndEvaluate("2020-03-12||/w", datemath.WithLocale(fakeLocale{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRound, _ := time.Parse(time.RFC3339, "2020-03-08T00:00:00Z")
+	if !out.Equal(wantRound) {
+		t.Errorf("ParseAndEvaluate(2020-03-12||/w) with WithLocale = %s, want %s", out, wantRound)
+	}
+}
+
+// deLocale is a partial German Locale, used to exercise non-ASCII month
+// names such as "März".
+type deLocale struct{}
+
+var deMonths = []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"}
+
+func (deLocale) MonthWide(m time.Month) string            { return deMonths[m-1] }
+func (deLocale) MonthAbbreviated(m time.Month) string     { return deMonths[m-1][:3] }
+func (deLocale) WeekdayWide(d time.Weekday) string        { return d.String() }
+func (deLocale) WeekdayAbbreviated(d time.Weekday) string { return d.String()[:3] }
+
+func TestWithLocaleNonASCII(t *testing.T) {
+	got, err := datemath.ParseAnchor("17 März 2012", datemath.WithLocale(deLocale{}))
+	if err != nil {
+		t.Fatalf("ParseAnchor returned error %q", err)
+	}
+	want, _ := time.Parse(time.RFC3339, "2012-03-17T00:00:00Z")
+	if !got.Equal(want) {
+		t.Errorf("ParseAnchor(%q) with a non-ASCII locale month name = %s, want %s", "17 März 2012", got, want)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2014-11-18T14:27:32Z")
+
+	r, err := datemath.ParseRange("now-7d..now", datemath.WithNow(now))
+	if err != nil {
+		t.Fatalf("ParseRange returned error %q", err)
+	}
+	if !r.StartInclusive || !r.EndInclusive {
+		t.Fatalf("ParseRange(%q) = %+v, want both endpoints inclusive", "now-7d..now", r)
+	}
+	if !r.Contains(now) {
+		t.Errorf("Range %+v should contain %s", r, now)
+	}
+	if r.Contains(now.AddDate(0, 0, -8)) {
+		t.Errorf("Range %+v should not contain a time before its start", r)
+	}
+
+	buckets, err := r.Split("1d")
+	if err != nil {
+		t.Fatalf("Split returned error %q", err)
+	}
+	if len(buckets) != 7 {
+		t.Errorf("Split(\"1d\") returned %d buckets, want 7", len(buckets))
+	}
+
+	if _, err := r.Split("0d"); err == nil {
+		t.Error("Split(\"0d\") returned no error, want an error rejecting a non-positive step")
+	}
+
+	// "bd" is round-only (see TestWithBusinessCalendar); using it as a step
+	// must be rejected, since addUnit doesn't implement it and Split could
+	// never advance with it.
+	if _, err := r.Split("1bd"); err == nil {
+		t.Error("Split(\"1bd\") returned no error, want an error rejecting the round-only \"bd\" unit")
+	}
+}
+
+// fakeCalendar is a 9-to-5, Monday-Friday BusinessCalendar for testing
+// WithBusinessCalendar.
+type fakeCalendar struct{}
+
+func (fakeCalendar) IsBusinessDay(t time.Time) bool {
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+func (fakeCalendar) DayWindow(time.Time) (open, close time.Duration) {
+	return 9 * time.Hour, 17 * time.Hour
+}
+
+func (fakeCalendar) Location() *time.Location { return time.UTC }
+
+func TestWithBusinessCalendar(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2014-11-18T09:00:00Z") // a Tuesday
+
+	out, err := datemath.ParseAndEvaluate("now+16bh", datemath.WithNow(now), datemath.WithBusinessCalendar(fakeCalendar{}))
+	if err != nil {
+		t.Fatalf("ParseAndEvaluate returned error %q", err)
+	}
+	want, _ := time.Parse(time.RFC3339, "2014-11-19T17:00:00Z")
+	if !out.Equal(want) {
+		t.Errorf("now+16bh = %s, want %s", out, want)
+	}
+
+	out, err = datemath.ParseAndEvaluate("now||/bd", datemath.WithNow(now), datemath.WithBusinessCalendar(fakeCalendar{}))
+	if err != nil {
+		t.Fatalf("ParseAndEvaluate returned error %q", err)
+	}
+	want, _ = time.Parse(time.RFC3339, "2014-11-18T09:00:00Z")
+	if !out.Equal(want) {
+		t.Errorf("now||/bd = %s, want %s", out, want)
+	}
+
+	// "bd" only makes sense as a "/bd" rounding boundary; using it with
+	// "+"/"-" is rejected rather than silently making no progress.
+	if _, err := datemath.ParseAndEvaluate("now+3bd", datemath.WithNow(now)); err == nil {
+		t.Error("now+3bd returned no error, want an error rejecting the round-only \"bd\" unit")
+	}
+
+	// Rounding with "/bd" or "/bh" on a day the calendar doesn't consider a
+	// business day must snap to a real business window rather than treating
+	// that day as if it were open.
+	saturday, _ := time.Parse(time.RFC3339, "2014-11-22T13:00:00Z")
+	out, err = datemath.ParseAndEvaluate("now||/bd", datemath.WithNow(saturday), datemath.WithBusinessCalendar(fakeCalendar{}))
+	if err != nil {
+		t.Fatalf("ParseAndEvaluate returned error %q", err)
+	}
+	want, _ = time.Parse(time.RFC3339, "2014-11-21T09:00:00Z") // the preceding Friday's open
+	if !out.Equal(want) {
+		t.Errorf("Saturday now||/bd = %s, want %s", out, want)
+	}
+}
+
+func TestExpressionMarshaling(t *testing.T) {
+	expr, err := datemath.Parse("2014-05-30||+1d")
+	if err != nil {
+		t.Fatalf("Parse returned error %q", err)
+	}
+
+	data, err := json.Marshal(expr)
+	if err != nil {
+		t.Fatalf("json.Marshal returned error %q", err)
+	}
+
+	// Unmarshaling into an interface field requires a non-nil value already
+	// stored in it, per Expression's doc comment.
+	roundTripped, err := datemath.Parse("now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &roundTripped); err != nil {
+		t.Fatalf("json.Unmarshal(%s) returned error %q", data, err)
+	}
+
+	want, got := expr.Time(), roundTripped.Time()
+	if !got.Equal(want) {
+		t.Errorf("round-tripped expression evaluates to %s, want %s", got, want)
+	}
+}
+
+// TestExprFieldUnmarshal exercises the case Expression's own doc comment
+// calls out: a struct field declared with the concrete Expr type, rather
+// than the Expression interface, must decode straight from JSON without
+// first being primed by a call to Parse.
+func TestExprFieldUnmarshal(t *testing.T) {
+	var cfg struct {
+		Start datemath.Expr `json:"start"`
+	}
+	if err := json.Unmarshal([]byte(`{"start":"2014-05-30||+1d"}`), &cfg); err != nil {
+		t.Fatalf("json.Unmarshal into a zero-value Expr field returned error %q", err)
+	}
+	want, _ := time.Parse(time.RFC3339, "2014-05-31T00:00:00Z")
+	if got := cfg.Start.Time(); !got.Equal(want) {
+		t.Errorf("cfg.Start.Time() = %s, want %s", got, want)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	fixed, _ := time.Parse(time.RFC3339, "2020-01-01T00:00:00Z")
+	out, err := datemath.ParseAndEvaluate("now", datemath.WithClock(func() time.Time { return fixed }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Equal(fixed) {
+		t.Errorf("ParseAndEvaluate with WithClock = %s, want %s", out, fixed)
+	}
+
+	explicit, _ := time.Parse(time.RFC3339, "2021-06-01T00:00:00Z")
+	out, err = datemath.ParseAndEvaluate("now", datemath.WithClock(func() time.Time { return fixed }), datemath.WithNow(explicit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Equal(explicit) {
+		t.Errorf("WithNow should take precedence over WithClock: got %s, want %s", out, explicit)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2020-01-01T12:00:00Z")
+	target := now.Add(-15 * time.Minute)
+
+	got := datemath.Format(target, "now", datemath.WithNow(now))
+	if want := "now-15m"; got != want {
+		t.Errorf("Format = %q, want %q", got, want)
+	}
+
+	if got := datemath.Format(now, "now", datemath.WithNow(now)); got != "now" {
+		t.Errorf("Format with zero delta = %q, want %q", got, "now")
+	}
+}
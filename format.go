@@ -0,0 +1,63 @@
+package datemath
+
+import (
+	"fmt"
+	"time"
+)
+
+// formatUnits are tried, coarsest first, when Format looks for a unit that
+// divides a delta evenly; "b" and "bh" are deliberately excluded since a
+// count of business days/hours can't be recovered from a plain duration
+// without re-walking a calendar.
+var formatUnits = []struct {
+	unit string
+	dur  time.Duration
+}{
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+}
+
+// Format expresses t relative to expr (typically "now"), producing a
+// compact datemath string such as "now-15m" or "now+2h" -- the inverse of
+// ParseAndEvaluate. It reports just expr's own canonical form when t
+// exactly matches expr's evaluated time, and falls back to t's own
+// canonical form if expr fails to parse or evaluate.
+//
+// The delta is expressed using the coarsest of "d", "h", "m", or "s" that
+// divides it evenly, falling back to whole seconds (rounding towards zero)
+// otherwise.
+func Format(t time.Time, expr string, opts ...Option) string {
+	parsed, err := parseExpression(expr, opts...)
+	if err != nil {
+		return formatAnchor(dateAnchor{hasAbs: true, abs: t})
+	}
+	ref := parsed.Time(opts...)
+
+	delta := t.Sub(ref)
+	if delta == 0 {
+		return parsed.String()
+	}
+
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+
+	var amount time.Duration
+	unit := "s"
+	found := false
+	for _, u := range formatUnits {
+		if delta%u.dur == 0 {
+			amount, unit, found = delta/u.dur, u.unit, true
+			break
+		}
+	}
+	if !found {
+		amount = delta / time.Second
+	}
+
+	return fmt.Sprintf("%s%s%d%s", parsed.String(), sign, amount, unit)
+}
@@ -0,0 +1,228 @@
+package datemath
+
+import "fmt"
+
+// tokenType identifies the lexical class of a token produced by the lexer.
+// The names intentionally mirror what a generated parser would emit so that
+// syntax errors read the same way regardless of which token failed to match.
+type tokenType int
+
+const (
+	tEOF tokenType = iota
+	tDIGIT
+	tNOW
+	tUNIT
+	tDASH
+	tPLUS
+	tSLASH
+	tPIPE
+	tCOLON
+	tDOT
+	tT
+	tZ
+	tINVALID_TOKEN
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case tEOF:
+		return "tEOF"
+	case tDIGIT:
+		return "tDIGIT"
+	case tNOW:
+		return "tNOW"
+	case tUNIT:
+		return "tUNIT"
+	case tDASH:
+		return "tDASH"
+	case tPLUS:
+		return "tPLUS"
+	case tSLASH:
+		return "tSLASH"
+	case tPIPE:
+		return "tPIPE"
+	case tCOLON:
+		return "tCOLON"
+	case tDOT:
+		return "tDOT"
+	case tT:
+		return "tT"
+	case tZ:
+		return "tZ"
+	case tINVALID_TOKEN:
+		return "tINVALID_TOKEN"
+	default:
+		return "tUNKNOWN"
+	}
+}
+
+// token is a single lexical unit along with the byte offset it ended at,
+// used for error reporting.
+type token struct {
+	typ  tokenType
+	val  string
+	pos  int // 1-indexed byte position immediately following the token
+}
+
+// units are the literal unit words recognized by the lexer. Case matters:
+// M is month, m is minute; H is hour-of-day, h is hour.
+var units = []string{"y", "Q", "M", "w", "d", "h", "H", "m", "s", "b", "bh", "bd", "fy", "fQ"}
+
+// roundOnlyUnits are unit words valid after "/" but not after "+"/"-": "bd"
+// only names a business day's open-close window for rounding purposes,
+// unlike "bh" (also addable) or "b" (the addable business-day unit).
+var roundOnlyUnits = map[string]bool{"bd": true}
+
+func isKeywordPrefix(s string) bool {
+	for _, u := range units {
+		if len(s) <= len(u) && u[:len(s)] == s {
+			return true
+		}
+	}
+	return len(s) <= len("now") && "now"[:len(s)] == s
+}
+
+func keywordToken(s string) (tokenType, bool) {
+	if s == "now" {
+		return tNOW, true
+	}
+	for _, u := range units {
+		if u == s {
+			return tUNIT, true
+		}
+	}
+	return 0, false
+}
+
+// hasLongerKeyword reports whether some keyword strictly longer than s shares
+// s as a prefix, meaning the lexer must keep reading to disambiguate.
+func hasLongerKeyword(s string) bool {
+	for _, u := range units {
+		if len(u) > len(s) && len(s) <= len(u) && u[:len(s)] == s {
+			return true
+		}
+	}
+	if len(s) < len("now") && "now"[:len(s)] == s {
+		return true
+	}
+	return false
+}
+
+// lexer tokenizes a datemath expression one token at a time.
+type lexer struct {
+	input string
+	pos   int // byte offset of the next unread character
+	base  int // offset of input[0] within the original expression, for error positions
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{input: input}
+}
+
+// newLexerAt is used when input is a substring of a larger expression (e.g.
+// the math suffix found after a flexibly-parsed anchor) so that reported
+// error positions stay relative to the original expression.
+func newLexerAt(input string, base int) *lexer {
+	return &lexer{input: input, base: base}
+}
+
+func (l *lexer) next() token {
+	if l.pos >= len(l.input) {
+		return token{typ: tEOF, pos: l.base + l.pos + 1}
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c >= '0' && c <= '9':
+		return l.lexDigits()
+	// 'T' and 'Z' must be checked before isWordStart(c): both are valid
+	// word-start bytes, but neither is a prefix of "now" or any unit word,
+	// so leaving them to lexWord() would make them dead code that always
+	// lexes to tINVALID_TOKEN instead of the RFC3339 date/time separator
+	// and UTC designator they're meant to be.
+	case c == 'T':
+		l.pos++
+		return token{typ: tT, val: "T", pos: l.base + l.pos + 1}
+	case c == 'Z':
+		l.pos++
+		return token{typ: tZ, val: "Z", pos: l.base + l.pos + 1}
+	case isWordStart(c):
+		return l.lexWord()
+	case c == '-':
+		l.pos++
+		return token{typ: tDASH, val: "-", pos: l.base + l.pos + 1}
+	case c == '+':
+		l.pos++
+		return token{typ: tPLUS, val: "+", pos: l.base + l.pos + 1}
+	case c == '/':
+		l.pos++
+		return token{typ: tSLASH, val: "/", pos: l.base + l.pos + 1}
+	case c == '|':
+		l.pos++
+		return token{typ: tPIPE, val: "|", pos: l.base + l.pos + 1}
+	case c == ':':
+		l.pos++
+		return token{typ: tCOLON, val: ":", pos: l.base + l.pos + 1}
+	case c == '.':
+		l.pos++
+		return token{typ: tDOT, val: ".", pos: l.base + l.pos + 1}
+	default:
+		start := l.pos
+		l.pos++
+		return token{typ: tINVALID_TOKEN, val: l.input[start:l.pos], pos: l.base + l.pos + 1}
+	}
+}
+
+func isWordStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (l *lexer) lexDigits() token {
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] >= '0' && l.input[l.pos] <= '9' {
+		l.pos++
+	}
+	return token{typ: tDIGIT, val: l.input[start:l.pos], pos: l.base + l.pos + 1}
+}
+
+// lexWord implements maximal-munch matching against the fixed keyword set
+// (now, and the unit words). It keeps extending the accumulated prefix while
+// a longer keyword could still match; as soon as the next character rules
+// every keyword out, that character is still consumed and the accumulated
+// text is returned as an invalid token, matching how far the lexer had
+// committed before giving up.
+func (l *lexer) lexWord() token {
+	start := l.pos
+	accum := ""
+	for l.pos < len(l.input) && isWordStart(l.input[l.pos]) {
+		candidate := accum + string(l.input[l.pos])
+		if !isKeywordPrefix(candidate) {
+			accum = candidate
+			l.pos++
+			return token{typ: tINVALID_TOKEN, val: accum, pos: l.base + l.pos + 1}
+		}
+		accum = candidate
+		l.pos++
+		if typ, ok := keywordToken(accum); ok && !hasLongerKeyword(accum) {
+			return token{typ: typ, val: accum, pos: l.base + l.pos + 1}
+		}
+	}
+	if typ, ok := keywordToken(accum); ok {
+		return token{typ: typ, val: accum, pos: l.base + l.pos + 1}
+	}
+	return token{typ: tINVALID_TOKEN, val: l.input[start:l.pos], pos: l.base + l.pos + 1}
+}
+
+// syntaxError formats a parser error in the style of a generated parser's
+// "unexpected X, expecting Y" message.
+func syntaxError(got token, expecting ...tokenType) error {
+	expect := expecting[0].String()
+	for _, e := range expecting[1:] {
+		expect += " or " + e.String()
+	}
+	preview := got.val
+	if preview == "" {
+		preview = "EOF"
+	}
+	return fmt.Errorf("syntax error: unexpected %s, expecting %s at character %d starting with %q", got.typ, expect, got.pos, preview)
+}
@@ -0,0 +1,83 @@
+package datemath
+
+import (
+	"strings"
+	"time"
+)
+
+// Locale supplies localized month and weekday names so that ParseAnchor (and
+// the flexible anchor fallback used by Parse) can recognize anchors written
+// in other languages, such as the Dutch "maart" or German "März". It is
+// intentionally just the four name-lookup methods of a
+// github.com/go-playground/locales Translator, so a Translator value can be
+// passed to WithLocale directly without this package depending on that
+// module.
+type Locale interface {
+	MonthWide(time.Month) string
+	MonthAbbreviated(time.Month) string
+	WeekdayWide(time.Weekday) string
+	WeekdayAbbreviated(time.Weekday) string
+}
+
+// localeFirstDayer is an optional extension a Locale may implement to report
+// the first day of its week, consulted by "/w" rounding when
+// WithStartOfWeek hasn't been set explicitly. A Locale that doesn't satisfy
+// it leaves the default start of week at time.Monday, same as with no
+// locale at all.
+type localeFirstDayer interface {
+	FirstDay() time.Weekday
+}
+
+// WithLocale sets the locale used to recognize month and weekday names in
+// anchors, and, for locales that report one, the default start of week for
+// "/w" rounding. Defaults to nil, which recognizes English names only.
+func WithLocale(l Locale) Option {
+	return func(o *Options) { o.locale = l }
+}
+
+// localeMonthLayout looks up text against locale's month names, returning
+// the Go reference-time month token ("January" or "Jan") to put in the
+// layout along with the equivalent English month name to substitute into
+// the string actually handed to time.Parse -- the time package's "January"
+// and "Jan" verbs only ever recognize English names, so the localized token
+// can't be parsed as-is.
+func localeMonthLayout(l Locale, text string) (layoutTok, englishTok string, ok bool) {
+	if l == nil {
+		return "", "", false
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(text, l.MonthWide(m)) {
+			return "January", m.String(), true
+		}
+		if strings.EqualFold(text, l.MonthAbbreviated(m)) {
+			return "Jan", m.String()[:3], true
+		}
+	}
+	return "", "", false
+}
+
+// localeWeekdayLayout is localeMonthLayout's counterpart for weekday names.
+func localeWeekdayLayout(l Locale, text string) (layoutTok, englishTok string, ok bool) {
+	if l == nil {
+		return "", "", false
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(text, l.WeekdayWide(d)) {
+			return "Monday", d.String(), true
+		}
+		if strings.EqualFold(text, l.WeekdayAbbreviated(d)) {
+			return "Mon", d.String()[:3], true
+		}
+	}
+	return "", "", false
+}
+
+// localeFirstDay returns l's first day of week, if l is non-nil and
+// implements localeFirstDayer.
+func localeFirstDay(l Locale) (time.Weekday, bool) {
+	fd, ok := l.(localeFirstDayer)
+	if !ok {
+		return 0, false
+	}
+	return fd.FirstDay(), true
+}
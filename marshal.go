@@ -0,0 +1,94 @@
+package datemath
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// String re-serializes e from its parsed AST rather than echoing whatever
+// string it was parsed from, so it always reflects what e will actually
+// evaluate to. See Expression.String.
+func (e *Expr) String() string {
+	s := "now"
+	if !e.isNow {
+		s = formatAnchor(e.anchor)
+	}
+	if len(e.ops) == 0 {
+		return s
+	}
+	s += "||"
+	for _, op := range e.ops {
+		s += formatOp(op)
+	}
+	return s
+}
+
+// formatAnchor renders a dateAnchor back into the strict grammar's anchor
+// syntax. A zone suffix is only included when a.loc was set explicitly, so
+// that re-parsing the result still defers to Options.location exactly as
+// the original anchor did.
+func formatAnchor(a dateAnchor) string {
+	if a.hasAbs {
+		return a.abs.Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	layout := "2006-01-02T15:04:05.000"
+	loc := a.loc
+	if loc != nil {
+		layout += "Z07:00"
+	} else {
+		loc = time.UTC
+	}
+	t := time.Date(a.year, time.Month(a.month), a.day, a.hour, a.min, a.sec, a.nsec, loc)
+	return t.Format(layout)
+}
+
+// formatOp renders a single math operation back into its "+Nunit",
+// "-Nunit", or "/unit" source form.
+func formatOp(op mathOp) string {
+	switch op.kind {
+	case opAdd:
+		return fmt.Sprintf("+%d%s", op.amount, op.unit)
+	case opSub:
+		return fmt.Sprintf("-%d%s", op.amount, op.unit)
+	case opRound:
+		return "/" + op.unit
+	default:
+		return ""
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, returning e's canonical
+// string form (see String).
+func (e *Expr) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, replacing e in place
+// with the result of parsing data using the strict grammar -- the only
+// grammar String's output ever needs, since it always emits an ISO-ish
+// anchor rather than the free-form dates the flexible parser exists for.
+func (e *Expr) UnmarshalText(data []byte) error {
+	parsed, err := parseExpressionStrict(string(data))
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding e as the JSON string of
+// its canonical form (see String).
+func (e *Expr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, replacing e in place with the
+// expression parsed from the JSON string data.
+func (e *Expr) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return e.UnmarshalText([]byte(s))
+}
@@ -0,0 +1,362 @@
+package datemath
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// opKind identifies what a single math operation does to the anchor time.
+type opKind int
+
+const (
+	opAdd opKind = iota
+	opSub
+	opRound
+)
+
+// mathOp is one `+Nunit`, `-Nunit`, or `/unit` operation parsed from the
+// `||<math>` suffix of an expression.
+type mathOp struct {
+	kind   opKind
+	amount int
+	unit   string
+}
+
+// dateAnchor is the anchor half of a parsed expression, prior to any
+// `opts.location`/`opts.now` being applied. Fields left unset take the
+// zero value for the field they represent (e.g. a bare "2014" leaves
+// month and day at 1).
+type dateAnchor struct {
+	hasAbs bool
+	abs    time.Time
+
+	year, month, day      int
+	hour, min, sec, nsec  int
+	loc                   *time.Location // explicit zone, if any; nil means "use Options.location"
+}
+
+// Expr is the concrete type Parse and the flexible/strict anchor parsers
+// return, satisfying Expression. It's exported, and its zero value is a
+// valid json.Unmarshaler target, so a struct field can be declared as
+// datemath.Expr and decoded straight from JSON/text without first needing
+// to be primed by a call to Parse.
+type Expr struct {
+	isNow  bool
+	anchor dateAnchor
+	ops    []mathOp
+}
+
+// withOp returns a copy of e with op appended to its operations, leaving e
+// itself untouched.
+func (e *Expr) withOp(op mathOp) Expression {
+	next := *e
+	next.ops = append(append([]mathOp{}, e.ops...), op)
+	return &next
+}
+
+// Add returns a copy of e with a "+amount<unit>" operation appended.
+func (e *Expr) Add(amount int, unit string) Expression {
+	return e.withOp(mathOp{kind: opAdd, amount: amount, unit: unit})
+}
+
+// Sub returns a copy of e with a "-amount<unit>" operation appended.
+func (e *Expr) Sub(amount int, unit string) Expression {
+	return e.withOp(mathOp{kind: opSub, amount: amount, unit: unit})
+}
+
+// Round returns a copy of e with a "/unit" rounding operation appended.
+func (e *Expr) Round(unit string) Expression {
+	return e.withOp(mathOp{kind: opRound, unit: unit})
+}
+
+// parseExpression parses a full datemath expression, trying the strict
+// grammar first and falling back to flexible anchor parsing (see anchor.go)
+// for anchors the strict grammar doesn't recognize. The strict error is
+// preserved and returned if the flexible parser also can't make sense of s,
+// so unrecognized input still gets the familiar "syntax error: ..." message.
+func parseExpression(s string, opts ...Option) (*Expr, error) {
+	expr, err := parseExpressionStrict(s)
+	if err == nil {
+		return expr, nil
+	}
+	if flexExpr, ferr := parseExpressionFlexible(s, opts); ferr == nil {
+		return flexExpr, nil
+	}
+	return nil, err
+}
+
+// parseExpressionStrict implements the original, narrow grammar: an anchor
+// (`now` or an RFC3339-ish date or epoch millis) optionally followed by `||`
+// and a sequence of math operations.
+func parseExpressionStrict(s string) (*Expr, error) {
+	lx := newLexer(s)
+	tok := lx.next()
+
+	var expr Expr
+	switch tok.typ {
+	case tNOW:
+		expr.isNow = true
+		tok = lx.next()
+	case tDIGIT:
+		anchor, rest, err := parseDate(lx, tok)
+		if err != nil {
+			return nil, err
+		}
+		expr.anchor = anchor
+		tok = rest
+	default:
+		return nil, syntaxError(tok, tNOW, tDIGIT)
+	}
+
+	if tok.typ == tPIPE {
+		second := lx.next()
+		if second.typ != tPIPE {
+			return nil, syntaxError(second, tPIPE)
+		}
+		tok = lx.next()
+	}
+
+	ops, err := parseMathOps(lx, tok)
+	if err != nil {
+		return nil, err
+	}
+	expr.ops = ops
+
+	return &expr, nil
+}
+
+// parseMathOps parses zero or more `+Nunit`, `-Nunit`, or `/unit`
+// operations, with tok already holding the first unconsumed token.
+func parseMathOps(lx *lexer, tok token) ([]mathOp, error) {
+	var ops []mathOp
+	for {
+		switch tok.typ {
+		case tEOF:
+			return ops, nil
+		case tPLUS, tDASH:
+			kind := opAdd
+			if tok.typ == tDASH {
+				kind = opSub
+			}
+			next := lx.next()
+			amount := 1
+			if next.typ == tDIGIT {
+				amount, _ = strconv.Atoi(next.val)
+				next = lx.next()
+			}
+			if next.typ != tUNIT {
+				return nil, syntaxError(next, tUNIT)
+			}
+			if roundOnlyUnits[next.val] {
+				return nil, fmt.Errorf("unit %q is only valid with \"/\" rounding, not \"+\"/\"-\", at character %d starting with %q", next.val, next.pos, next.val)
+			}
+			ops = append(ops, mathOp{kind: kind, amount: amount, unit: next.val})
+			tok = lx.next()
+		case tSLASH:
+			next := lx.next()
+			if next.typ != tUNIT {
+				return nil, syntaxError(next, tUNIT)
+			}
+			ops = append(ops, mathOp{kind: opRound, unit: next.val})
+			tok = lx.next()
+		default:
+			return nil, syntaxError(tok, tPLUS, tDASH, tSLASH)
+		}
+	}
+}
+
+// parseDate parses the anchor date following the first digit run, dispatching
+// to the time-only, epoch-millis, or year/month/day form depending on what
+// follows it.
+func parseDate(lx *lexer, firstTok token) (dateAnchor, token, error) {
+	next := lx.next()
+
+	if next.typ == tCOLON {
+		return parseTimeOnly(lx, firstTok)
+	}
+
+	if (next.typ == tEOF || next.typ == tPIPE) && len(firstTok.val) != 4 {
+		return parseEpochMillis(firstTok, next)
+	}
+
+	return parseYMD(lx, firstTok, next)
+}
+
+func parseEpochMillis(tok, rest token) (dateAnchor, token, error) {
+	ms, err := strconv.ParseInt(tok.val, 10, 64)
+	if err != nil {
+		return dateAnchor{}, token{}, fmt.Errorf("invalid epoch millis %q at character %d starting with %q", tok.val, tok.pos, tok.val)
+	}
+	return dateAnchor{hasAbs: true, abs: time.UnixMilli(ms).UTC()}, rest, nil
+}
+
+func parseTimeOnly(lx *lexer, hourTok token) (dateAnchor, token, error) {
+	a := dateAnchor{year: 1970, month: 1, day: 1}
+
+	hour, _ := strconv.Atoi(hourTok.val)
+	if hour < 0 || hour > 23 {
+		return a, token{}, fmt.Errorf("hour %d out of bounds at character %d starting with %q", hour, hourTok.pos, hourTok.val)
+	}
+	a.hour = hour
+
+	minTok := lx.next()
+	if minTok.typ != tDIGIT {
+		return a, token{}, syntaxError(minTok, tDIGIT)
+	}
+	min, _ := strconv.Atoi(minTok.val)
+	if min < 0 || min > 59 {
+		return a, token{}, fmt.Errorf("minute %d out of bounds at character %d starting with %q", min, minTok.pos, minTok.val)
+	}
+	a.min = min
+
+	tok := lx.next()
+	if tok.typ == tCOLON {
+		secTok := lx.next()
+		if secTok.typ != tDIGIT {
+			return a, token{}, syntaxError(secTok, tDIGIT)
+		}
+		sec, _ := strconv.Atoi(secTok.val)
+		if sec < 0 || sec > 59 {
+			return a, token{}, fmt.Errorf("second %d out of bounds at character %d starting with %q", sec, secTok.pos, secTok.val)
+		}
+		a.sec = sec
+		tok = lx.next()
+	}
+
+	return a, tok, nil
+}
+
+func parseYMD(lx *lexer, yearTok, tok token) (dateAnchor, token, error) {
+	var a dateAnchor
+
+	year, err := strconv.Atoi(yearTok.val)
+	if err != nil || len(yearTok.val) != 4 {
+		return a, token{}, fmt.Errorf("invalid year %q at character %d starting with %q", yearTok.val, yearTok.pos, yearTok.val)
+	}
+	a.year, a.month, a.day = year, 1, 1
+
+	if tok.typ != tDASH {
+		return a, tok, nil
+	}
+	monthTok := lx.next()
+	if monthTok.typ != tDIGIT {
+		return a, token{}, syntaxError(monthTok, tDIGIT)
+	}
+	month, _ := strconv.Atoi(monthTok.val)
+	if month < 1 || month > 12 {
+		return a, token{}, fmt.Errorf("month %d out of bounds at character %d starting with %q", month, monthTok.pos, monthTok.val)
+	}
+	a.month = month
+
+	tok = lx.next()
+	if tok.typ != tDASH {
+		return a, tok, nil
+	}
+	dayTok := lx.next()
+	if dayTok.typ != tDIGIT {
+		return a, token{}, syntaxError(dayTok, tDIGIT)
+	}
+	day, _ := strconv.Atoi(dayTok.val)
+	if day < 1 || day > daysInMonth(a.year, a.month) {
+		return a, token{}, fmt.Errorf("day %d out of bounds for month %d at character %d starting with %q", day, a.month, dayTok.pos, dayTok.val[len(dayTok.val)-1:])
+	}
+	a.day = day
+
+	tok = lx.next()
+	if tok.typ != tT {
+		return a, tok, nil
+	}
+	hourTok := lx.next()
+	if hourTok.typ != tDIGIT {
+		return a, token{}, syntaxError(hourTok, tDIGIT)
+	}
+	hour, _ := strconv.Atoi(hourTok.val)
+	if hour < 0 || hour > 23 {
+		return a, token{}, fmt.Errorf("hour %d out of bounds at character %d starting with %q", hour, hourTok.pos, hourTok.val)
+	}
+	a.hour = hour
+
+	tok = lx.next()
+	if tok.typ == tCOLON {
+		minTok := lx.next()
+		if minTok.typ != tDIGIT {
+			return a, token{}, syntaxError(minTok, tDIGIT)
+		}
+		min, _ := strconv.Atoi(minTok.val)
+		if min < 0 || min > 59 {
+			return a, token{}, fmt.Errorf("minute %d out of bounds at character %d starting with %q", min, minTok.pos, minTok.val)
+		}
+		a.min = min
+
+		tok = lx.next()
+		if tok.typ == tCOLON {
+			secTok := lx.next()
+			if secTok.typ != tDIGIT {
+				return a, token{}, syntaxError(secTok, tDIGIT)
+			}
+			sec, _ := strconv.Atoi(secTok.val)
+			if sec < 0 || sec > 59 {
+				return a, token{}, fmt.Errorf("second %d out of bounds at character %d starting with %q", sec, secTok.pos, secTok.val)
+			}
+			a.sec = sec
+
+			tok = lx.next()
+			if tok.typ == tDOT {
+				msTok := lx.next()
+				if msTok.typ != tDIGIT {
+					return a, token{}, syntaxError(msTok, tDIGIT)
+				}
+				ms, _ := strconv.Atoi(padRight(msTok.val, 3))
+				a.nsec = ms * int(time.Millisecond)
+				tok = lx.next()
+			}
+		}
+	}
+
+	switch tok.typ {
+	case tZ:
+		a.loc = time.UTC
+		tok = lx.next()
+	case tPLUS, tDASH:
+		sign := 1
+		if tok.typ == tDASH {
+			sign = -1
+		}
+		hTok := lx.next()
+		if hTok.typ != tDIGIT {
+			return a, token{}, syntaxError(hTok, tDIGIT)
+		}
+		zh, _ := strconv.Atoi(hTok.val)
+		zm := 0
+		tok = lx.next()
+		if tok.typ == tCOLON {
+			mTok := lx.next()
+			if mTok.typ != tDIGIT {
+				return a, token{}, syntaxError(mTok, tDIGIT)
+			}
+			zm, _ = strconv.Atoi(mTok.val)
+			tok = lx.next()
+		}
+		offset := sign * (zh*3600 + zm*60)
+		a.loc = time.FixedZone(fmt.Sprintf("%+03d:%02d", sign*zh, zm), offset)
+	}
+
+	return a, tok, nil
+}
+
+func daysInMonth(year, month int) int {
+	return time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// padRight pads a fractional-seconds digit string to n digits so that ".1"
+// is interpreted as 100ms rather than 1ms.
+func padRight(s string, n int) string {
+	for len(s) < n {
+		s += "0"
+	}
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package datemath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is a parsed "<start>..<end>" datemath range, such as "now-7d..now"
+// or "2014-11-01..2014-11-18||/d". Either endpoint may be open: an omitted
+// start ("..now") or end ("now..") leaves the corresponding Inclusive flag
+// false and that time.Time at its zero value, meaning "no bound on this
+// side".
+type Range struct {
+	Start, End                   time.Time
+	StartInclusive, EndInclusive bool
+}
+
+// ParseRange parses a range expression and evaluates both endpoints
+// immediately -- there's no lazy Expression-like counterpart here, since a
+// range depending on "now" is normally meant to be evaluated once, at query
+// time, not stored and re-evaluated later.
+//
+// Each endpoint is parsed and evaluated using the same math/rounding
+// expression grammar as Parse. Per Elasticsearch's inclusive-range
+// convention, a "/unit" rounding operation on an endpoint rounds down by
+// default at the start of the range and up at the end, so
+// "2014-11-01..2014-11-18||/d" includes all of November 18th. An explicit
+// WithRoundUp among opts overrides this default for both endpoints alike.
+func ParseRange(s string, opts ...Option) (Range, error) {
+	idx := strings.Index(s, "..")
+	if idx < 0 {
+		return Range{}, fmt.Errorf("invalid range %q: missing \"..\" separator", s)
+	}
+	startStr, endStr := s[:idx], s[idx+2:]
+	if startStr == "" && endStr == "" {
+		return Range{}, fmt.Errorf("invalid range %q: at least one endpoint is required", s)
+	}
+
+	var r Range
+	if startStr != "" {
+		t, err := evalRangeEndpoint(startStr, opts, false)
+		if err != nil {
+			return Range{}, err
+		}
+		r.Start, r.StartInclusive = t, true
+	}
+	if endStr != "" {
+		t, err := evalRangeEndpoint(endStr, opts, true)
+		if err != nil {
+			return Range{}, err
+		}
+		r.End, r.EndInclusive = t, true
+	}
+	return r, nil
+}
+
+// evalRangeEndpoint parses and evaluates one side of a range, defaulting
+// roundUp for that side before applying the caller's own opts, so an
+// explicit WithRoundUp among opts still takes precedence.
+func evalRangeEndpoint(s string, opts []Option, defaultRoundUp bool) (time.Time, error) {
+	expr, err := parseExpression(s, opts...)
+	if err != nil {
+		return time.Time{}, err
+	}
+	allOpts := append([]Option{WithRoundUp(defaultRoundUp)}, opts...)
+	return expr.Time(allOpts...), nil
+}
+
+// Contains reports whether t falls within r, treating an open endpoint as
+// having no constraint on that side.
+func (r Range) Contains(t time.Time) bool {
+	if r.StartInclusive && t.Before(r.Start) {
+		return false
+	}
+	if r.EndInclusive && t.After(r.End) {
+		return false
+	}
+	return true
+}
+
+// Duration returns r.End.Sub(r.Start). It's only meaningful when both
+// endpoints are bounded.
+func (r Range) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Split divides a fully-bounded r into consecutive buckets of the given step
+// (e.g. "1d", "6h"), for building the buckets a time-series query needs.
+// Each bucket is inclusive at both ends, so adjacent buckets share their
+// boundary instant. The final bucket is truncated to r.End if step doesn't
+// divide the range evenly.
+func (r Range) Split(step string) ([]Range, error) {
+	if !r.StartInclusive || !r.EndInclusive {
+		return nil, fmt.Errorf("cannot split a range with an open endpoint")
+	}
+	amount, unit, err := parseStep(step)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []Range
+	for cur := r.Start; cur.Before(r.End); {
+		next := addUnit(cur, amount, unit, defaultOptions())
+		if next.After(r.End) {
+			next = r.End
+		}
+		if !next.After(cur) {
+			return nil, fmt.Errorf("step %q made no progress splitting range", step)
+		}
+		buckets = append(buckets, Range{Start: cur, End: next, StartInclusive: true, EndInclusive: true})
+		cur = next
+	}
+	return buckets, nil
+}
+
+// parseStep parses a bucket size such as "1d" or "6h" using the same unit
+// vocabulary as a math operation's "+N<unit>".
+func parseStep(step string) (int, string, error) {
+	lx := newLexer(step)
+	tok := lx.next()
+	amount := 1
+	if tok.typ == tDIGIT {
+		amount, _ = strconv.Atoi(tok.val)
+		tok = lx.next()
+	}
+	if tok.typ != tUNIT {
+		return 0, "", syntaxError(tok, tUNIT)
+	}
+	if next := lx.next(); next.typ != tEOF {
+		return 0, "", syntaxError(next, tEOF)
+	}
+	if amount <= 0 {
+		return 0, "", fmt.Errorf("invalid step %q: amount must be positive", step)
+	}
+	if roundOnlyUnits[tok.val] {
+		return 0, "", fmt.Errorf("invalid step %q: unit %q is only valid with \"/\" rounding", step, tok.val)
+	}
+	return amount, tok.val, nil
+}